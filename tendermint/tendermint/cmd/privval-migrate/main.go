@@ -0,0 +1,104 @@
+// Command privval-migrate is a one-shot tool that rewrites an existing
+// priv_validator.json onto the split PrivValidatorFS/LastSignedInfo layout
+// and re-encodes LastSignBytes from the old CanonicalJSON form into the
+// new binary CanonicalVote/CanonicalProposal form, so an upgraded node
+// doesn't trip the "Conflicting data" branch the first time it's asked to
+// sign again at the same height/round/step.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	crypto "github.com/tendermint/go-crypto"
+	data "github.com/tendermint/go-wire/data"
+
+	"github.com/tendermint/tendermint/types"
+)
+
+// legacyPrivValidatorFS mirrors the pre-migration priv_validator.json
+// layout, where HRS/signature state and the key lived in a single file.
+type legacyPrivValidatorFS struct {
+	Address       data.Bytes       `json:"address"`
+	PubKey        crypto.PubKey    `json:"pub_key"`
+	LastHeight    int64            `json:"last_height"`
+	LastRound     int              `json:"last_round"`
+	LastStep      int8             `json:"last_step"`
+	LastSignature crypto.Signature `json:"last_signature,omitempty"`
+	LastSignBytes data.Bytes       `json:"last_signbytes,omitempty"`
+	PrivKey       crypto.PrivKey   `json:"priv_key"`
+}
+
+func main() {
+	filePath := flag.String("priv-validator-file", "", "path to the legacy priv_validator.json to migrate")
+	flag.Parse()
+	if *filePath == "" {
+		fmt.Fprintln(os.Stderr, "usage: privval-migrate --priv-validator-file priv_validator.json")
+		os.Exit(1)
+	}
+
+	if err := migrate(*filePath); err != nil {
+		fmt.Fprintln(os.Stderr, "Error migrating", *filePath+":", err)
+		os.Exit(1)
+	}
+}
+
+func migrate(filePath string) error {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	var legacy legacyPrivValidatorFS
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return fmt.Errorf("reading legacy priv_validator.json: %v", err)
+	}
+
+	newSignBytes, err := migrateSignBytes(legacy.LastStep, legacy.LastSignBytes)
+	if err != nil {
+		return fmt.Errorf("migrating LastSignBytes: %v", err)
+	}
+
+	lsi := types.NewLastSignedInfo(filepath.Join(filepath.Dir(filePath), "last_sign_state.json"))
+	lsi.Height = legacy.LastHeight
+	lsi.Round = legacy.LastRound
+	lsi.Step = legacy.LastStep
+	lsi.Signature = legacy.LastSignature
+	lsi.SignBytes = newSignBytes
+	lsi.Save()
+
+	pv := types.NewPrivValidatorFS(filePath, legacy.Address, legacy.PubKey, legacy.PrivKey, lsi)
+	pv.Save()
+
+	fmt.Println("Migrated", filePath, "and wrote", lsi.Height, "/", lsi.Round, "/", lsi.Step, "to", "last_sign_state.json")
+	return nil
+}
+
+// migrateSignBytes decodes the old CanonicalJSON-encoded sign bytes for a
+// vote or proposal (the step tells us which) and re-encodes them with the
+// new Vote.SignBytes/Proposal.SignBytes binary layout. A zero-length
+// legacySignBytes (a validator that has never signed) yields nil and no
+// error.
+func migrateSignBytes(step int8, legacySignBytes []byte) ([]byte, error) {
+	if len(legacySignBytes) == 0 {
+		return nil, nil
+	}
+
+	if step == 1 { // stepPropose
+		var cp types.CanonicalJSONOnceProposal
+		if err := json.Unmarshal(legacySignBytes, &cp); err != nil {
+			return nil, err
+		}
+		return cp.Proposal.SignBytes(cp.ChainID), nil
+	}
+
+	var cv types.CanonicalJSONOnceVote
+	if err := json.Unmarshal(legacySignBytes, &cv); err != nil {
+		return nil, err
+	}
+	return cv.Vote.SignBytes(cv.ChainID), nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateSignBytesPassesThroughEmptyInput(t *testing.T) {
+	out, err := migrateSignBytes(0, nil)
+	require.NoError(t, err)
+	assert.Nil(t, out)
+
+	out, err = migrateSignBytes(1, []byte{})
+	require.NoError(t, err)
+	assert.Nil(t, out)
+}
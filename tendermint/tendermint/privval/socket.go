@@ -0,0 +1,192 @@
+// Package privval provides out-of-process implementations of
+// types.PrivValidator, starting with a RemoteSigner that answers requests
+// from a types.SocketPV over an authenticated TCP socket.
+package privval
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	wire "github.com/tendermint/go-wire"
+	cmn "github.com/tendermint/tmlibs/common"
+	"github.com/tendermint/tmlibs/log"
+
+	"github.com/tendermint/tendermint/p2p"
+	"github.com/tendermint/tendermint/types"
+)
+
+const (
+	acceptDeadline    = time.Second * 3
+	readWriteDeadline = time.Second * 5
+)
+
+// ErrUnauthorizedNode is returned by authenticate when the connecting
+// node's long-term pubkey, learned during the secret connection handshake,
+// doesn't match the authorizedPubKey the RemoteSigner was configured with.
+var ErrUnauthorizedNode = errors.New("connecting node does not match the authorized pubkey")
+
+// RemoteSigner wraps a types.PrivValidatorFS and answers PubKeyRequest/
+// SignVoteRequest/SignProposalRequest/SignHeartbeatRequest over a socket,
+// so the key material backing it never has to leave this process. All
+// double-signing protection stays here: a compromised node can only ask
+// this signer to sign, never to forget what it has already signed.
+//
+// authorizedPubKey pins the handshake to the specific node this signer is
+// supposed to serve; without it, completing the secret connection DH
+// handshake is enough for any host that can reach the listening address to
+// have its SignVoteRequest/SignProposalRequest answered.
+type RemoteSigner struct {
+	cmn.BaseService
+
+	addr             string
+	chainID          string
+	privKey          crypto.PrivKeyEd25519 // ephemeral key for the secret connection handshake
+	authorizedPubKey crypto.PubKey
+	privVal          *types.PrivValidatorFS
+
+	listener net.Listener
+	logger   log.Logger
+}
+
+// NewRemoteSigner returns a RemoteSigner that will listen on addr and serve
+// requests on behalf of privVal, signing for the given chainID. Only a peer
+// whose secret connection handshake proves it holds authorizedPubKey is
+// served; pass an empty crypto.PubKey to accept any peer (eg. in tests).
+func NewRemoteSigner(logger log.Logger, addr, chainID string, privVal *types.PrivValidatorFS, authorizedPubKey crypto.PubKey) *RemoteSigner {
+	rs := &RemoteSigner{
+		addr:             addr,
+		chainID:          chainID,
+		privKey:          crypto.GenPrivKeyEd25519(),
+		authorizedPubKey: authorizedPubKey,
+		privVal:          privVal,
+		logger:           logger,
+	}
+	rs.BaseService = *cmn.NewBaseService(logger, "RemoteSigner", rs)
+	return rs
+}
+
+// OnStart implements cmn.Service. It starts listening and spawns the accept
+// loop in the background.
+func (rs *RemoteSigner) OnStart() error {
+	ln, err := net.Listen("tcp", rs.addr)
+	if err != nil {
+		return err
+	}
+	rs.listener = ln
+	go rs.acceptLoop()
+	return nil
+}
+
+// OnStop implements cmn.Service.
+func (rs *RemoteSigner) OnStop() {
+	if rs.listener != nil {
+		rs.listener.Close()
+	}
+}
+
+func (rs *RemoteSigner) acceptLoop() {
+	for {
+		conn, err := rs.listener.Accept()
+		if err != nil {
+			if !rs.IsRunning() {
+				return
+			}
+			rs.logger.Error("RemoteSigner accept failed", "err", err)
+			continue
+		}
+
+		secretConn, err := rs.authenticate(conn)
+		if err != nil {
+			rs.logger.Error("RemoteSigner handshake failed", "err", err)
+			conn.Close()
+			continue
+		}
+
+		go rs.handleConnection(secretConn)
+	}
+}
+
+func (rs *RemoteSigner) authenticate(conn net.Conn) (*p2p.SecretConnection, error) {
+	if err := conn.SetDeadline(time.Now().Add(acceptDeadline)); err != nil {
+		return nil, err
+	}
+	secretConn, err := p2p.MakeSecretConnection(conn, rs.privKey)
+	if err != nil {
+		return nil, err
+	}
+	if !rs.authorizedPubKey.Empty() && !secretConn.RemotePubKey().Equals(rs.authorizedPubKey) {
+		secretConn.Close()
+		return nil, ErrUnauthorizedNode
+	}
+	return secretConn, nil
+}
+
+func (rs *RemoteSigner) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		if !rs.IsRunning() {
+			return
+		}
+		conn.SetDeadline(time.Now().Add(readWriteDeadline))
+
+		var n int
+		var err error
+		var req types.RemoteSignerMsg
+		wire.ReadBinaryPtr(&req, conn, maxRemoteSignerMsgSize, &n, &err)
+		if err != nil {
+			if err.Error() != "EOF" {
+				rs.logger.Error("RemoteSigner failed to read request", "err", err)
+			}
+			return
+		}
+
+		res := rs.handleRequest(req)
+
+		wire.WriteBinary(res, conn, &n, &err)
+		if err != nil {
+			rs.logger.Error("RemoteSigner failed to write response", "err", err)
+			return
+		}
+	}
+}
+
+const maxRemoteSignerMsgSize = 1024 * 10
+
+func (rs *RemoteSigner) handleRequest(req types.RemoteSignerMsg) types.RemoteSignerMsg {
+	switch r := req.(type) {
+	case *types.PubKeyRequest:
+		return &types.PubKeyResponse{PubKey: rs.privVal.GetPubKey()}
+
+	case *types.SignVoteRequest:
+		if err := rs.privVal.SignVote(rs.chainID, r.Vote); err != nil {
+			return &types.SignedVoteResponse{Error: remoteSignerError(err)}
+		}
+		return &types.SignedVoteResponse{Vote: r.Vote}
+
+	case *types.SignProposalRequest:
+		if err := rs.privVal.SignProposal(rs.chainID, r.Proposal); err != nil {
+			return &types.SignedProposalResponse{Error: remoteSignerError(err)}
+		}
+		return &types.SignedProposalResponse{Proposal: r.Proposal}
+
+	case *types.SignHeartbeatRequest:
+		if err := rs.privVal.SignHeartbeat(rs.chainID, r.Heartbeat); err != nil {
+			return &types.SignedHeartbeatResponse{Error: remoteSignerError(err)}
+		}
+		return &types.SignedHeartbeatResponse{Heartbeat: r.Heartbeat}
+
+	case *types.PingRequest:
+		return &types.PingResponse{}
+
+	default:
+		return &types.PubKeyResponse{Error: remoteSignerError(fmt.Errorf("unknown request type %T", req))}
+	}
+}
+
+func remoteSignerError(err error) *types.RemoteSignerError {
+	return &types.RemoteSignerError{Description: err.Error()}
+}
@@ -0,0 +1,56 @@
+package privval
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tendermint/tendermint/types"
+)
+
+func newTestPrivValidatorFS(t *testing.T) (*types.PrivValidatorFS, func()) {
+	dir, err := ioutil.TempDir("", "remotesigner_test")
+	require.NoError(t, err)
+	pv := types.GenPrivValidatorFS(filepath.Join(dir, "priv_validator.json"))
+	return pv, func() { os.RemoveAll(dir) }
+}
+
+func TestRemoteSignerHandleRequestDispatchesPubKeyRequest(t *testing.T) {
+	privVal, cleanup := newTestPrivValidatorFS(t)
+	defer cleanup()
+	rs := &RemoteSigner{privVal: privVal, chainID: "test-chain"}
+
+	res := rs.handleRequest(&types.PubKeyRequest{})
+	pkResp, ok := res.(*types.PubKeyResponse)
+	require.True(t, ok)
+	assert.Nil(t, pkResp.Error)
+	assert.Equal(t, privVal.GetPubKey(), pkResp.PubKey)
+}
+
+func TestRemoteSignerHandleRequestSignsVote(t *testing.T) {
+	privVal, cleanup := newTestPrivValidatorFS(t)
+	defer cleanup()
+	rs := &RemoteSigner{privVal: privVal, chainID: "test-chain"}
+	vote := &types.Vote{Height: 1, Round: 0, Type: types.VoteTypePrecommit, BlockID: types.BlockID{}}
+
+	res := rs.handleRequest(&types.SignVoteRequest{Vote: vote})
+	voteResp, ok := res.(*types.SignedVoteResponse)
+	require.True(t, ok)
+	require.Nil(t, voteResp.Error)
+	assert.False(t, voteResp.Vote.Signature.Empty())
+}
+
+func TestRemoteSignerHandleRequestRejectsUnknownType(t *testing.T) {
+	privVal, cleanup := newTestPrivValidatorFS(t)
+	defer cleanup()
+	rs := &RemoteSigner{privVal: privVal, chainID: "test-chain"}
+
+	res := rs.handleRequest(struct{}{})
+	pkResp, ok := res.(*types.PubKeyResponse)
+	require.True(t, ok)
+	assert.NotNil(t, pkResp.Error)
+}
@@ -0,0 +1,127 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	crypto "github.com/tendermint/go-crypto"
+	data "github.com/tendermint/go-wire/data"
+)
+
+// ErrConflictingSignBytes is returned by LastSignedInfo.Sign when the
+// requested signBytes don't match what was last signed for the same
+// height/round/step (and don't merely differ by timestamp). It carries
+// enough of the conflicting payload for the caller to turn the refusal
+// into DuplicateVoteEvidence.
+type ErrConflictingSignBytes struct {
+	LastSignBytes data.Bytes
+	LastSignature crypto.Signature
+	NewSignBytes  data.Bytes
+}
+
+func (e *ErrConflictingSignBytes) Error() string {
+	return "Conflicting data"
+}
+
+// EvidenceSink accepts evidence of byzantine behaviour discovered locally
+// (eg. a local double-signing attempt) so it can be gossiped by the
+// mempool/reactor and eventually used to slash the offending validator.
+type EvidenceSink interface {
+	Submit(ev *DuplicateVoteEvidence) error
+}
+
+// FileEvidenceSink is the default EvidenceSink: it appends newline-
+// delimited JSON evidence records to a file, so evidence survives even if
+// nothing is listening to gossip it yet.
+type FileEvidenceSink struct {
+	filePath string
+	mtx      sync.Mutex
+}
+
+// NewFileEvidenceSink returns a FileEvidenceSink that appends to filePath,
+// creating it if necessary.
+func NewFileEvidenceSink(filePath string) *FileEvidenceSink {
+	return &FileEvidenceSink{filePath: filePath}
+}
+
+// Submit implements EvidenceSink.
+func (fes *FileEvidenceSink) Submit(ev *DuplicateVoteEvidence) error {
+	fes.mtx.Lock()
+	defer fes.mtx.Unlock()
+
+	f, err := os.OpenFile(fes.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	jsonBytes, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(jsonBytes, '\n'))
+	return err
+}
+
+// SetEvidenceSink attaches an EvidenceSink that SignVote will report
+// detected double-sign attempts to. Passing nil disables reporting.
+func (pv *PrivValidatorFS) SetEvidenceSink(sink EvidenceSink) {
+	pv.mtx.Lock()
+	defer pv.mtx.Unlock()
+	pv.EvidenceSink = sink
+}
+
+// DuplicateVoteEvidence proves that PubKey signed two different votes at
+// the same height/round/step. Both votes are fully signed, so the
+// evidence is self-contained and verifiable by anyone holding chainID.
+type DuplicateVoteEvidence struct {
+	PubKey crypto.PubKey
+	VoteA  *Vote
+	VoteB  *Vote
+}
+
+// Height returns the height at which the double-sign occurred.
+func (dve *DuplicateVoteEvidence) Height() int64 {
+	return dve.VoteA.Height
+}
+
+// Address returns the address of the equivocating validator.
+func (dve *DuplicateVoteEvidence) Address() data.Bytes {
+	return dve.PubKey.Address()
+}
+
+// Verify checks that this is indeed valid evidence of equivocation for chainID.
+func (dve *DuplicateVoteEvidence) Verify(chainID string) error {
+	return VerifyDuplicateVoteEvidence(chainID, dve)
+}
+
+// String returns a string representation of the evidence.
+func (dve *DuplicateVoteEvidence) String() string {
+	return fmt.Sprintf("DuplicateVoteEvidence{%v voted twice at H/R/S %v/%v/%v}",
+		dve.PubKey.Address(), dve.VoteA.Height, dve.VoteA.Round, voteToStep(dve.VoteA))
+}
+
+// VerifyDuplicateVoteEvidence checks that ev's two votes were both signed
+// by ev.PubKey at the same height/round/type but for different block IDs
+// -- ie. that it really is proof of equivocation, and not, say, two
+// otherwise-identical votes re-signed after a crash.
+func VerifyDuplicateVoteEvidence(chainID string, ev *DuplicateVoteEvidence) error {
+	voteA, voteB := ev.VoteA, ev.VoteB
+
+	if voteA.Height != voteB.Height || voteA.Round != voteB.Round || voteA.Type != voteB.Type {
+		return errors.New("DuplicateVoteEvidence: votes are not for the same height/round/type")
+	}
+	if voteA.BlockID.Equals(voteB.BlockID) {
+		return errors.New("DuplicateVoteEvidence: votes are for the same block, not a duplicate")
+	}
+	if !ev.PubKey.VerifyBytes(SignBytes(chainID, voteA), voteA.Signature) {
+		return errors.New("DuplicateVoteEvidence: VoteA signature does not match PubKey")
+	}
+	if !ev.PubKey.VerifyBytes(SignBytes(chainID, voteB), voteB.Signature) {
+		return errors.New("DuplicateVoteEvidence: VoteB signature does not match PubKey")
+	}
+	return nil
+}
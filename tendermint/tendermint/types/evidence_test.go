@@ -0,0 +1,68 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	data "github.com/tendermint/go-wire/data"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	blockIDA = BlockID{Hash: data.Bytes("block-a")}
+	blockIDB = BlockID{Hash: data.Bytes("block-b")}
+)
+
+func newTestVote(privKey crypto.PrivKey, chainID string, height int64, round int, voteType int8, blockID BlockID) *Vote {
+	vote := &Vote{
+		Height:    height,
+		Round:     round,
+		Type:      voteType,
+		BlockID:   blockID,
+		Timestamp: time.Now(),
+	}
+	vote.Signature = privKey.Sign(vote.SignBytes(chainID))
+	return vote
+}
+
+func TestVerifyDuplicateVoteEvidencePasses(t *testing.T) {
+	privKey := crypto.GenPrivKeyEd25519().Wrap()
+	chainID := "test-chain"
+	voteA := newTestVote(privKey, chainID, 10, 0, VoteTypePrecommit, blockIDA)
+	voteB := newTestVote(privKey, chainID, 10, 0, VoteTypePrecommit, blockIDB)
+
+	ev := &DuplicateVoteEvidence{PubKey: privKey.PubKey(), VoteA: voteA, VoteB: voteB}
+	assert.NoError(t, VerifyDuplicateVoteEvidence(chainID, ev))
+}
+
+func TestVerifyDuplicateVoteEvidenceRejectsSameBlockID(t *testing.T) {
+	privKey := crypto.GenPrivKeyEd25519().Wrap()
+	chainID := "test-chain"
+	voteA := newTestVote(privKey, chainID, 10, 0, VoteTypePrecommit, blockIDA)
+	voteB := newTestVote(privKey, chainID, 10, 0, VoteTypePrecommit, blockIDA)
+
+	ev := &DuplicateVoteEvidence{PubKey: privKey.PubKey(), VoteA: voteA, VoteB: voteB}
+	assert.Error(t, VerifyDuplicateVoteEvidence(chainID, ev))
+}
+
+func TestVerifyDuplicateVoteEvidenceRejectsMismatchedHRS(t *testing.T) {
+	privKey := crypto.GenPrivKeyEd25519().Wrap()
+	chainID := "test-chain"
+	voteA := newTestVote(privKey, chainID, 10, 0, VoteTypePrecommit, blockIDA)
+	voteB := newTestVote(privKey, chainID, 11, 0, VoteTypePrecommit, blockIDB)
+
+	ev := &DuplicateVoteEvidence{PubKey: privKey.PubKey(), VoteA: voteA, VoteB: voteB}
+	assert.Error(t, VerifyDuplicateVoteEvidence(chainID, ev))
+}
+
+func TestVerifyDuplicateVoteEvidenceRejectsBadSignature(t *testing.T) {
+	privKey := crypto.GenPrivKeyEd25519().Wrap()
+	otherKey := crypto.GenPrivKeyEd25519().Wrap()
+	chainID := "test-chain"
+	voteA := newTestVote(privKey, chainID, 10, 0, VoteTypePrecommit, blockIDA)
+	voteB := newTestVote(otherKey, chainID, 10, 0, VoteTypePrecommit, blockIDB)
+
+	ev := &DuplicateVoteEvidence{PubKey: privKey.PubKey(), VoteA: voteA, VoteB: voteB}
+	assert.Error(t, VerifyDuplicateVoteEvidence(chainID, ev))
+}
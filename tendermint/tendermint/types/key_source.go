@@ -0,0 +1,126 @@
+package types
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	crypto "github.com/tendermint/go-crypto"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+// KeySource fetches the validator's current signing key from wherever it
+// is actually managed (a config server, a KMS, an HSM...), along with the
+// height from which that key becomes valid. It replaces the old
+// GetPrikeyFromConfigServer stub on PrivValidator with something a
+// PrivValidatorFS can actually poll.
+type KeySource interface {
+	Fetch(ctx context.Context) (privKey crypto.PrivKey, validFromHeight int64, err error)
+}
+
+// HTTPKeySource is a KeySource that authenticates with a client
+// certificate over TLS and pulls the current key, plus its rotation
+// metadata, from a configured URL.
+type HTTPKeySource struct {
+	URL    string
+	Client *http.Client
+}
+
+// httpKeySourceResponse is the wire format served by the config server.
+type httpKeySourceResponse struct {
+	PrivKey         crypto.PrivKey `json:"priv_key"`
+	ValidFromHeight int64          `json:"valid_from_height"`
+}
+
+// NewHTTPKeySource returns an HTTPKeySource that authenticates to url with
+// the given client certificate and trusts rootCAs (nil for the system
+// pool).
+func NewHTTPKeySource(url string, cert tls.Certificate, rootCAs *x509.CertPool) *HTTPKeySource {
+	return &HTTPKeySource{
+		URL: url,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					Certificates: []tls.Certificate{cert},
+					RootCAs:      rootCAs,
+				},
+			},
+		},
+	}
+}
+
+// Fetch implements KeySource.
+func (hks *HTTPKeySource) Fetch(ctx context.Context) (crypto.PrivKey, int64, error) {
+	req, err := http.NewRequest("GET", hks.URL, nil)
+	if err != nil {
+		return crypto.PrivKey{}, 0, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := hks.Client.Do(req)
+	if err != nil {
+		return crypto.PrivKey{}, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return crypto.PrivKey{}, 0, fmt.Errorf("key source %s returned %s", hks.URL, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return crypto.PrivKey{}, 0, err
+	}
+
+	var res httpKeySourceResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return crypto.PrivKey{}, 0, fmt.Errorf(cmn.Fmt("Error decoding key source response: %v", err))
+	}
+	return res.PrivKey, res.ValidFromHeight, nil
+}
+
+// SetKeySource attaches a KeySource that PollKeySource will query. Passing
+// nil disables polling.
+func (pv *PrivValidatorFS) SetKeySource(ks KeySource) {
+	pv.mtx.Lock()
+	defer pv.mtx.Unlock()
+	pv.KeySource = ks
+}
+
+// PollKeySource asks the configured KeySource for the current key and, if
+// it reports a validFromHeight beyond what we've already signed at,
+// atomically swaps in the new key. It is a no-op if no KeySource is set.
+// Safe to call both on startup and periodically thereafter.
+func (pv *PrivValidatorFS) PollKeySource(ctx context.Context) error {
+	pv.mtx.Lock()
+	ks := pv.KeySource
+	pv.mtx.Unlock()
+	if ks == nil {
+		return nil
+	}
+
+	privKey, validFromHeight, err := ks.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	pv.mtx.Lock()
+	defer pv.mtx.Unlock()
+
+	if validFromHeight <= pv.LastSignedInfo.GetHeight() {
+		return nil
+	}
+
+	pv.oldPrivKey = privKey
+	pv.PrivKey = privKey
+	pv.PubKey = privKey.PubKey()
+	pv.Address = privKey.PubKey().Address()
+	pv.Signer = NewDefaultSigner(privKey)
+	pv.LastSignedInfo.ResetSignature()
+	pv.save()
+	return nil
+}
@@ -0,0 +1,271 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	wire "github.com/tendermint/go-wire"
+	data "github.com/tendermint/go-wire/data"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+const lastSignStateFileName = "last_sign_state.json"
+
+// lastSignStatePath derives the default LastSignedInfo file for a
+// priv_validator.json-style key file: a sibling last_sign_state.json in
+// the same directory. Keeping it separate from the key file lets the key
+// file be mounted read-only from a secret store while HRS state still
+// gets persisted on every sign.
+func lastSignStatePath(privValFilePath string) string {
+	return filepath.Join(filepath.Dir(privValFilePath), lastSignStateFileName)
+}
+
+// LastSignedInfo tracks the height/round/step of, and signature produced
+// for, the last message this validator signed. It is the sole source of
+// truth for double-signing protection: any PrivValidator implementation
+// (PrivValidatorFS, a socket signer, a KMS-backed signer, ...) can embed
+// one of these and get the anti-equivocation logic for free, without
+// copying checkHRS/saveSigned by hand.
+type LastSignedInfo struct {
+	Height    int64            `json:"height"`
+	Round     int              `json:"round"`
+	Step      int8             `json:"step"`
+	Signature crypto.Signature `json:"signature,omitempty"` // so we dont lose signatures
+	SignBytes data.Bytes       `json:"signbytes,omitempty"` // so we dont lose signatures
+
+	// For persistence.
+	// Overloaded for testing.
+	filePath string
+	mtx      sync.Mutex
+}
+
+// NewLastSignedInfo returns a zeroed LastSignedInfo that will persist to
+// filePath, but does not save it.
+func NewLastSignedInfo(filePath string) *LastSignedInfo {
+	return &LastSignedInfo{
+		Step:     stepNone,
+		filePath: filePath,
+	}
+}
+
+// LoadLastSignedInfo loads a LastSignedInfo from filePath.
+func LoadLastSignedInfo(filePath string) (*LastSignedInfo, error) {
+	jsonBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	lsi := &LastSignedInfo{}
+	if err := json.Unmarshal(jsonBytes, lsi); err != nil {
+		return nil, fmt.Errorf(cmn.Fmt("Error reading LastSignedInfo from %v: %v", filePath, err))
+	}
+	lsi.filePath = filePath
+	return lsi, nil
+}
+
+// LoadOrGenLastSignedInfo loads a LastSignedInfo from filePath, or else
+// returns a fresh one (and saves it) if filePath does not exist yet.
+func LoadOrGenLastSignedInfo(filePath string) *LastSignedInfo {
+	if _, err := ioutil.ReadFile(filePath); err != nil {
+		lsi := NewLastSignedInfo(filePath)
+		lsi.Save()
+		return lsi
+	}
+	lsi, err := LoadLastSignedInfo(filePath)
+	if err != nil {
+		cmn.Exit(err.Error())
+	}
+	return lsi
+}
+
+// GetHeight returns the height of the last message signed, under lock, for
+// callers (eg. PollKeySource) that run concurrently with Sign.
+func (lsi *LastSignedInfo) GetHeight() int64 {
+	lsi.mtx.Lock()
+	defer lsi.mtx.Unlock()
+	return lsi.Height
+}
+
+// Save persists the LastSignedInfo to disk.
+func (lsi *LastSignedInfo) Save() {
+	lsi.mtx.Lock()
+	defer lsi.mtx.Unlock()
+	lsi.save()
+}
+
+func (lsi *LastSignedInfo) save() {
+	if lsi.filePath == "" {
+		cmn.PanicSanity("Cannot save LastSignedInfo: filePath not set")
+	}
+	jsonBytes, err := json.Marshal(lsi)
+	if err != nil {
+		cmn.PanicCrisis(err)
+	}
+	if err := cmn.WriteFileAtomic(lsi.filePath, jsonBytes, 0600); err != nil {
+		cmn.PanicCrisis(err)
+	}
+}
+
+// ResetSignature clears the last signature/signBytes without touching the
+// HRS, so a key rotation can't be replayed against the old key's
+// signature but still benefits from HRS regression checks against the new
+// one.
+func (lsi *LastSignedInfo) ResetSignature() {
+	lsi.mtx.Lock()
+	defer lsi.mtx.Unlock()
+	lsi.Signature = crypto.Signature{}
+	lsi.SignBytes = nil
+	lsi.save()
+}
+
+// Reset zeroes out the HRS/signature state.
+// NOTE: Unsafe!
+func (lsi *LastSignedInfo) Reset() {
+	lsi.mtx.Lock()
+	defer lsi.mtx.Unlock()
+	lsi.Height = 0
+	lsi.Round = 0
+	lsi.Step = 0
+	lsi.Signature = crypto.Signature{}
+	lsi.SignBytes = nil
+	lsi.save()
+}
+
+// ModifyLastHeight fast-forwards the last signed height, eg. after
+// fast-syncing to the chain tip. NOTE: Unsafe!
+func (lsi *LastSignedInfo) ModifyLastHeight(h int64) {
+	lsi.mtx.Lock()
+	defer lsi.mtx.Unlock()
+	lsi.Height = h
+	lsi.Round = 0
+	lsi.Step = stepPrecommit
+	lsi.Signature = crypto.Signature{}
+	lsi.SignBytes = nil
+	lsi.save()
+}
+
+// checkHRS returns an error on HRS regression, or true if the HRS exactly
+// matches the last one signed (in which case the caller should check
+// whether it's safe to return the previous signature instead of signing
+// again).
+func (lsi *LastSignedInfo) checkHRS(height int64, round int, step int8) (bool, error) {
+	if lsi.Height > height {
+		return false, fmt.Errorf("Height regression. Got %v, last height %v", height, lsi.Height)
+	}
+
+	if lsi.Height == height {
+		if lsi.Round > round {
+			return false, fmt.Errorf("Round regression at height %v. Got %v, last round %v", height, round, lsi.Round)
+		}
+
+		if lsi.Round == round {
+			if lsi.Step > step {
+				return false, fmt.Errorf("Step regression at height %v round %v. Got %v, last step %v", height, round, step, lsi.Step)
+			} else if lsi.Step == step {
+				if lsi.SignBytes != nil {
+					if lsi.Signature.Empty() {
+						panic("LastSignedInfo: SignBytes is not nil but Signature is!")
+					}
+					return true, nil
+				}
+				return false, errors.New("No LastSignature found")
+			}
+		}
+	}
+	return false, nil
+}
+
+// checkOnlyDifferByTimestamp is used to tell whether two sign requests for
+// the same HRS are identical modulo timestamp, in which case it's safe to
+// resign with the previous signature instead of erroring.
+type checkOnlyDifferByTimestamp func(lastSignBytes, newSignBytes []byte) bool
+
+// Sign signs signBytes for the given height/round/step (HRS) using signer,
+// after checking it against the HRS of the last message signed. If the HRS
+// match and the payload only differs from the last one by its timestamp
+// (per checkFn), it returns the previous signature rather than signing
+// again, so a crash between signing and persisting the WAL entry can't be
+// tricked into producing two different signatures for the same HRS. The
+// chainID is accepted for parity with SignVote/SignProposal/SignHeartbeat;
+// it plays no role beyond having already been folded into signBytes.
+func (lsi *LastSignedInfo) Sign(chainID string, height int64, round int, step int8,
+	signBytes []byte, checkFn checkOnlyDifferByTimestamp, signer Signer) (crypto.Signature, error) {
+	lsi.mtx.Lock()
+	defer lsi.mtx.Unlock()
+
+	sig := crypto.Signature{}
+
+	sameHRS, err := lsi.checkHRS(height, round, step)
+	if err != nil {
+		return sig, err
+	}
+
+	// We might crash before writing to the wal,
+	// causing us to try to re-sign for the same HRS
+	if sameHRS {
+		// if they're the same or only differ by timestamp,
+		// return the last Signature. Otherwise, error
+		if bytes.Equal(signBytes, lsi.SignBytes) || checkFn(lsi.SignBytes, signBytes) {
+			return lsi.Signature, nil
+		}
+		return sig, &ErrConflictingSignBytes{
+			LastSignBytes: lsi.SignBytes,
+			LastSignature: lsi.Signature,
+			NewSignBytes:  signBytes,
+		}
+	}
+
+	sig, err = signer.Sign(signBytes)
+	if err != nil {
+		return sig, err
+	}
+	lsi.Height = height
+	lsi.Round = round
+	lsi.Step = step
+	lsi.Signature = sig
+	lsi.SignBytes = signBytes
+	lsi.save()
+	return sig, nil
+}
+
+// returns true if the only difference in the votes is their timestamp.
+// Decodes the binary CanonicalVote layout rather than unmarshalling JSON.
+func checkVotesOnlyDifferByTimestamp(lastSignBytes, newSignBytes []byte) bool {
+	var lastVote, newVote CanonicalVote
+	if err := wire.ReadBinaryBytes(lastSignBytes, &lastVote); err != nil {
+		panic(fmt.Sprintf("LastSignBytes cannot be decoded into a vote: %v", err))
+	}
+	if err := wire.ReadBinaryBytes(newSignBytes, &newVote); err != nil {
+		panic(fmt.Sprintf("signBytes cannot be decoded into a vote: %v", err))
+	}
+
+	// zero the timestamps and check equality
+	lastVote.Timestamp = time.Time{}
+	newVote.Timestamp = time.Time{}
+
+	return bytes.Equal(wire.BinaryBytes(newVote), wire.BinaryBytes(lastVote))
+}
+
+// returns true if the only difference in the proposals is their timestamp.
+// Decodes the binary CanonicalProposal layout rather than unmarshalling JSON.
+func checkProposalsOnlyDifferByTimestamp(lastSignBytes, newSignBytes []byte) bool {
+	var lastProposal, newProposal CanonicalProposal
+	if err := wire.ReadBinaryBytes(lastSignBytes, &lastProposal); err != nil {
+		panic(fmt.Sprintf("LastSignBytes cannot be decoded into a proposal: %v", err))
+	}
+	if err := wire.ReadBinaryBytes(newSignBytes, &newProposal); err != nil {
+		panic(fmt.Sprintf("signBytes cannot be decoded into a proposal: %v", err))
+	}
+
+	// zero the timestamps and check equality
+	lastProposal.Timestamp = time.Time{}
+	newProposal.Timestamp = time.Time{}
+
+	return bytes.Equal(wire.BinaryBytes(newProposal), wire.BinaryBytes(lastProposal))
+}
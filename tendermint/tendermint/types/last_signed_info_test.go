@@ -0,0 +1,89 @@
+package types
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	wire "github.com/tendermint/go-wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLastSignedInfo(t *testing.T) *LastSignedInfo {
+	f, err := ioutil.TempFile("", "last_sign_state_test")
+	require.NoError(t, err)
+	f.Close()
+	return NewLastSignedInfo(f.Name())
+}
+
+func TestLastSignedInfoSignsAtNewHRS(t *testing.T) {
+	lsi := newTestLastSignedInfo(t)
+	defer os.Remove(lsi.filePath)
+	signer := NewDefaultSigner(crypto.GenPrivKeyEd25519().Wrap())
+
+	sig, err := lsi.Sign("test-chain", 1, 0, stepPrecommit, []byte("signbytes-1"), checkVotesOnlyDifferByTimestamp, signer)
+	require.NoError(t, err)
+	assert.False(t, sig.Empty())
+	assert.EqualValues(t, 1, lsi.Height)
+}
+
+func TestLastSignedInfoRejectsHeightRegression(t *testing.T) {
+	lsi := newTestLastSignedInfo(t)
+	defer os.Remove(lsi.filePath)
+	signer := NewDefaultSigner(crypto.GenPrivKeyEd25519().Wrap())
+
+	_, err := lsi.Sign("test-chain", 10, 0, stepPrecommit, []byte("signbytes-10"), checkVotesOnlyDifferByTimestamp, signer)
+	require.NoError(t, err)
+
+	_, err = lsi.Sign("test-chain", 5, 0, stepPrecommit, []byte("signbytes-5"), checkVotesOnlyDifferByTimestamp, signer)
+	assert.Error(t, err)
+}
+
+func TestLastSignedInfoResignsIdenticalHRS(t *testing.T) {
+	lsi := newTestLastSignedInfo(t)
+	defer os.Remove(lsi.filePath)
+	signer := NewDefaultSigner(crypto.GenPrivKeyEd25519().Wrap())
+
+	sig1, err := lsi.Sign("test-chain", 1, 0, stepPrecommit, []byte("signbytes-1"), checkVotesOnlyDifferByTimestamp, signer)
+	require.NoError(t, err)
+
+	sig2, err := lsi.Sign("test-chain", 1, 0, stepPrecommit, []byte("signbytes-1"), checkVotesOnlyDifferByTimestamp, signer)
+	require.NoError(t, err)
+	assert.Equal(t, sig1, sig2)
+}
+
+func TestLastSignedInfoRejectsConflictingSignBytesAtSameHRS(t *testing.T) {
+	lsi := newTestLastSignedInfo(t)
+	defer os.Remove(lsi.filePath)
+	signer := NewDefaultSigner(crypto.GenPrivKeyEd25519().Wrap())
+	neverOnlyTimestamp := func(lastSignBytes, newSignBytes []byte) bool { return false }
+
+	_, err := lsi.Sign("test-chain", 1, 0, stepPrecommit, []byte("signbytes-1"), neverOnlyTimestamp, signer)
+	require.NoError(t, err)
+
+	_, err = lsi.Sign("test-chain", 1, 0, stepPrecommit, []byte("signbytes-2"), neverOnlyTimestamp, signer)
+	require.Error(t, err)
+	_, ok := err.(*ErrConflictingSignBytes)
+	assert.True(t, ok, "expected an ErrConflictingSignBytes, got %T", err)
+}
+
+func TestCheckVotesOnlyDifferByTimestamp(t *testing.T) {
+	base := CanonicalVote{
+		ChainID:   "test-chain",
+		Type:      PrecommitType,
+		Height:    1,
+		Round:     0,
+		BlockID:   BlockID{},
+		Timestamp: time.Now(),
+	}
+	onlyTimestampDiffers := base
+	onlyTimestampDiffers.Timestamp = base.Timestamp.Add(time.Second)
+	assert.True(t, checkVotesOnlyDifferByTimestamp(wire.BinaryBytes(base), wire.BinaryBytes(onlyTimestampDiffers)))
+
+	differentHeight := onlyTimestampDiffers
+	differentHeight.Height = 2
+	assert.False(t, checkVotesOnlyDifferByTimestamp(wire.BinaryBytes(base), wire.BinaryBytes(differentHeight)))
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -9,9 +10,9 @@ import (
 	"io/ioutil"
 	"os"
 	"sync"
-	"time"
 
 	crypto "github.com/tendermint/go-crypto"
+	wire "github.com/tendermint/go-wire"
 	data "github.com/tendermint/go-wire/data"
 	cmn "github.com/tendermint/tmlibs/common"
 )
@@ -54,20 +55,30 @@ type PrivValidator interface {
 
 // PrivValidatorFS implements PrivValidator using data persisted to disk
 // to prevent double signing. The Signer itself can be mutated to use
-// something besides the default, for instance a hardware signer.
+// something besides the default, for instance a hardware signer. Double-
+// signing protection is delegated to a LastSignedInfo, which persists
+// independently of the key file so the key file can be mounted read-only
+// from a secret store.
 type PrivValidatorFS struct {
-	Address       data.Bytes       `json:"address"`
-	PubKey        crypto.PubKey    `json:"pub_key"`
-	LastHeight    int64            `json:"last_height"`
-	LastRound     int              `json:"last_round"`
-	LastStep      int8             `json:"last_step"`
-	LastSignature crypto.Signature `json:"last_signature,omitempty"` // so we dont lose signatures
-	LastSignBytes data.Bytes       `json:"last_signbytes,omitempty"` // so we dont lose signatures
+	Address data.Bytes    `json:"address"`
+	PubKey  crypto.PubKey `json:"pub_key"`
 
 	// PrivKey should be empty if a Signer other than the default is being used.
 	PrivKey crypto.PrivKey `json:"priv_key"`
 	Signer  `json:"-"`
 
+	LastSignedInfo *LastSignedInfo `json:"-"`
+
+	// KeySource, if set, is polled by GetPrikeyFromConfigServer for a
+	// rotated signing key. Nil means keys are managed purely on disk.
+	KeySource `json:"-"`
+
+	// EvidenceSink, if set, receives DuplicateVoteEvidence whenever
+	// SignVote is asked to sign something conflicting with the last vote
+	// signed for the same height/round/step. Nil means a detected
+	// double-sign attempt is only ever refused, never reported.
+	EvidenceSink `json:"-"`
+
 	// For persistence.
 	// Overloaded for testing.
 	filePath   string
@@ -104,15 +115,21 @@ func (ds *DefaultSigner) Sign(msg []byte) (crypto.Signature, error) {
 // GetAddress returns the address of the validator.
 // Implements PrivValidator.
 func (pv *PrivValidatorFS) GetAddress() data.Bytes {
+	pv.mtx.Lock()
+	defer pv.mtx.Unlock()
 	return pv.Address
 }
 
 // GetPubKey returns the public key of the validator.
 // Implements PrivValidator.
 func (pv *PrivValidatorFS) GetPubKey() crypto.PubKey {
+	pv.mtx.Lock()
+	defer pv.mtx.Unlock()
 	return pv.PubKey
 }
 func (pv *PrivValidatorFS) GetPrikey() crypto.PrivKey {
+	pv.mtx.Lock()
+	defer pv.mtx.Unlock()
 	return pv.PrivKey
 }
 
@@ -121,13 +138,31 @@ func (pv *PrivValidatorFS) GetPrikey() crypto.PrivKey {
 func GenPrivValidatorFS(filePath string) *PrivValidatorFS {
 	privKey := crypto.GenPrivKeyEd25519().Wrap()
 	return &PrivValidatorFS{
-		Address:    privKey.PubKey().Address(),
-		PubKey:     privKey.PubKey(),
-		PrivKey:    privKey,
-		LastStep:   stepNone,
-		Signer:     NewDefaultSigner(privKey),
-		filePath:   filePath,
-		oldPrivKey: privKey,
+		Address:        privKey.PubKey().Address(),
+		PubKey:         privKey.PubKey(),
+		PrivKey:        privKey,
+		Signer:         NewDefaultSigner(privKey),
+		LastSignedInfo: NewLastSignedInfo(lastSignStatePath(filePath)),
+		filePath:       filePath,
+		oldPrivKey:     privKey,
+	}
+}
+
+// NewPrivValidatorFS constructs a PrivValidatorFS directly from already
+// validated key material (eg. for a migration tool rewriting an existing
+// priv_validator.json), rather than generating or loading one. oldPrivKey
+// is set to privKey, matching GenPrivValidatorFS and
+// LoadPrivValidatorFSWithSigner, so that Save persists privKey instead of
+// reverting to whatever the file previously held.
+func NewPrivValidatorFS(filePath string, address data.Bytes, pubKey crypto.PubKey, privKey crypto.PrivKey, lsi *LastSignedInfo) *PrivValidatorFS {
+	return &PrivValidatorFS{
+		Address:        address,
+		PubKey:         pubKey,
+		PrivKey:        privKey,
+		Signer:         NewDefaultSigner(privKey),
+		LastSignedInfo: lsi,
+		filePath:       filePath,
+		oldPrivKey:     privKey,
 	}
 }
 
@@ -171,6 +206,7 @@ func LoadPrivValidatorFSWithSigner(filePath string, signerFunc func(PrivValidato
 	privVal.PubKey = privVal.PrivKey.PubKey()
 	privVal.oldPrivKey = privVal.PrivKey
 	privVal.Signer = signerFunc(privVal)
+	privVal.LastSignedInfo = LoadOrGenLastSignedInfo(lastSignStatePath(filePath))
 	return privVal
 }
 
@@ -203,116 +239,92 @@ func (pv *PrivValidatorFS) save() {
 	}
 }
 
-// Reset resets all fields in the PrivValidatorFS.
+// Reset resets the double-signing protection state of the PrivValidatorFS.
 // NOTE: Unsafe!
 func (pv *PrivValidatorFS) Reset() {
-	pv.LastHeight = 0
-	pv.LastRound = 0
-	pv.LastStep = 0
-	pv.LastSignature = crypto.Signature{}
-	pv.LastSignBytes = nil
-	pv.Save()
+	pv.LastSignedInfo.Reset()
 }
 
 // SignVote signs a canonical representation of the vote, along with the
-// chainID. Implements PrivValidator.
+// chainID. Implements PrivValidator. If the vote conflicts with the last
+// one signed for the same height/round/step, it is refused as usual, but
+// is also turned into DuplicateVoteEvidence and handed to EvidenceSink (if
+// one is configured) so the double-sign attempt becomes slashable instead
+// of a silent refusal.
 func (pv *PrivValidatorFS) SignVote(chainID string, vote *Vote) error {
 	pv.mtx.Lock()
-	defer pv.mtx.Unlock()
-	signature, err := pv.signBytesHRS(vote.Height, vote.Round, voteToStep(vote),
-		SignBytes(chainID, vote), checkVotesOnlyDifferByTimestamp)
+	signer := pv.Signer
+	pv.mtx.Unlock()
+
+	signature, err := pv.LastSignedInfo.Sign(chainID, vote.Height, vote.Round, voteToStep(vote),
+		SignBytes(chainID, vote), checkVotesOnlyDifferByTimestamp, signer)
 	if err != nil {
+		if conflict, ok := err.(*ErrConflictingSignBytes); ok {
+			pv.reportDuplicateVote(chainID, vote, conflict, signer)
+		}
 		return errors.New(cmn.Fmt("Error signing vote: %v", err))
 	}
 	vote.Signature = signature
 	return nil
 }
 
-// SignProposal signs a canonical representation of the proposal, along with
-// the chainID. Implements PrivValidator.
-func (pv *PrivValidatorFS) SignProposal(chainID string, proposal *Proposal) error {
+// reportDuplicateVote reconstructs the previously-signed vote from the
+// conflict, fully signs the new one too (with the same signer SignVote
+// just used, so the second signature can't race a concurrent key
+// rotation), and submits the pair as DuplicateVoteEvidence. Errors here
+// (decoding, signing, or from Submit) are silently dropped, not logged:
+// SignVote still refuses to sign, which is the safety property that
+// matters.
+func (pv *PrivValidatorFS) reportDuplicateVote(chainID string, vote *Vote, conflict *ErrConflictingSignBytes, signer Signer) {
 	pv.mtx.Lock()
-	defer pv.mtx.Unlock()
-	signature, err := pv.signBytesHRS(proposal.Height, proposal.Round, stepPropose,
-		SignBytes(chainID, proposal), checkProposalsOnlyDifferByTimestamp)
-	if err != nil {
-		return fmt.Errorf("Error signing proposal: %v", err)
+	sink := pv.EvidenceSink
+	pubKey := pv.PubKey
+	pv.mtx.Unlock()
+	if sink == nil {
+		return
 	}
-	proposal.Signature = signature
-	return nil
-}
 
-// returns error if HRS regression or no LastSignBytes. returns true if HRS is unchanged
-func (pv *PrivValidatorFS) checkHRS(height int64, round int, step int8) (bool, error) {
-	if pv.LastHeight > height {
-		fmt.Printf("privVal.LastHeight=%d, height=%d\n", pv.LastHeight, height)
-		return false, errors.New("Height regression")
+	var cv CanonicalVote
+	if err := wire.ReadBinaryBytes(conflict.LastSignBytes, &cv); err != nil {
+		return
 	}
-
-	if pv.LastHeight == height {
-		if pv.LastRound > round {
-			return false, errors.New("Round regression")
-		}
-
-		if pv.LastRound == round {
-			if pv.LastStep > step {
-				return false, errors.New("Step regression")
-			} else if pv.LastStep == step {
-				if pv.LastSignBytes != nil {
-					if pv.LastSignature.Empty() {
-						panic("privVal: LastSignature is nil but LastSignBytes is not!")
-					}
-					return true, nil
-				}
-				return false, errors.New("No LastSignature found")
-			}
-		}
+	voteA := &Vote{
+		Height:    cv.Height,
+		Round:     int(cv.Round),
+		Type:      voteType(cv.Type),
+		BlockID:   cv.BlockID,
+		Timestamp: cv.Timestamp,
+		Signature: conflict.LastSignature,
 	}
-	return false, nil
-}
 
-// signBytesHRS signs the given signBytes if the height/round/step (HRS) are
-// greater than the latest state. If the HRS are equal and the only thing changed is the timestamp,
-// it returns the privValidator.LastSignature. Else it returns an error.
-func (pv *PrivValidatorFS) signBytesHRS(height int64, round int, step int8,
-	signBytes []byte, checkFn checkOnlyDifferByTimestamp) (crypto.Signature, error) {
-	sig := crypto.Signature{}
-
-	sameHRS, err := pv.checkHRS(height, round, step)
+	sigB, err := signer.Sign(conflict.NewSignBytes)
 	if err != nil {
-		return sig, err
+		return
 	}
+	voteB := *vote
+	voteB.Signature = sigB
 
-	// We might crash before writing to the wal,
-	// causing us to try to re-sign for the same HRS
-	if sameHRS {
-		// if they're the same or only differ by timestamp,
-		// return the LastSignature. Otherwise, error
-		if bytes.Equal(signBytes, pv.LastSignBytes) ||
-			checkFn(pv.LastSignBytes, signBytes) {
-			return pv.LastSignature, nil
-		}
-		return sig, fmt.Errorf("Conflicting data")
-	}
-
-	sig, err = pv.Sign(signBytes)
-	if err != nil {
-		return sig, err
-	}
-	pv.saveSigned(height, round, step, signBytes, sig)
-	return sig, nil
+	sink.Submit(&DuplicateVoteEvidence{
+		PubKey: pubKey,
+		VoteA:  voteA,
+		VoteB:  &voteB,
+	})
 }
 
-// Persist height/round/step and signature
-func (pv *PrivValidatorFS) saveSigned(height int64, round int, step int8,
-	signBytes []byte, sig crypto.Signature) {
+// SignProposal signs a canonical representation of the proposal, along with
+// the chainID. Implements PrivValidator.
+func (pv *PrivValidatorFS) SignProposal(chainID string, proposal *Proposal) error {
+	pv.mtx.Lock()
+	signer := pv.Signer
+	pv.mtx.Unlock()
 
-	pv.LastHeight = height
-	pv.LastRound = round
-	pv.LastStep = step
-	pv.LastSignature = sig
-	pv.LastSignBytes = signBytes
-	pv.save()
+	signature, err := pv.LastSignedInfo.Sign(chainID, proposal.Height, proposal.Round, stepPropose,
+		SignBytes(chainID, proposal), checkProposalsOnlyDifferByTimestamp, signer)
+	if err != nil {
+		return fmt.Errorf("Error signing proposal: %v", err)
+	}
+	proposal.Signature = signature
+	return nil
 }
 
 // SignHeartbeat signs a canonical representation of the heartbeat, along with the chainID.
@@ -327,13 +339,14 @@ func (pv *PrivValidatorFS) SignHeartbeat(chainID string, heartbeat *Heartbeat) e
 
 // String returns a string representation of the PrivValidatorFS.
 func (pv *PrivValidatorFS) String() string {
-	return fmt.Sprintf("PrivValidator{%v LH:%v, LR:%v, LS:%v}", pv.GetAddress(), pv.LastHeight, pv.LastRound, pv.LastStep)
+	lsi := pv.LastSignedInfo
+	return fmt.Sprintf("PrivValidator{%v LH:%v, LR:%v, LS:%v}", pv.GetAddress(), lsi.Height, lsi.Round, lsi.Step)
 }
 
-// GetPrikeyFromConfigServer recreate PrivValidator with new prikey from config server
+// GetPrikeyFromConfigServer recreates PrivValidator with a new prikey
+// fetched from the configured KeySource, if any. Implements PrivValidator.
 func (pv *PrivValidatorFS) GetPrikeyFromConfigServer() error {
-	//TODO
-	return nil
+	return pv.PollKeySource(context.Background())
 }
 
 func GenPubkey(pub string) crypto.PubKey {
@@ -349,13 +362,10 @@ func GenPubkey(pub string) crypto.PubKey {
 	return pubKey
 }
 
+// ModifyLastHeight fast-forwards the last signed height, eg. after
+// fast-syncing to the chain tip. Implements PrivValidator. NOTE: Unsafe!
 func (pv *PrivValidatorFS) ModifyLastHeight(h int64) {
-	pv.LastHeight = h
-	pv.LastRound = 0
-	pv.LastStep = 3
-	pv.LastSignature = crypto.Signature{}
-	pv.LastSignBytes = nil
-	pv.Save()
+	pv.LastSignedInfo.ModifyLastHeight(h)
 }
 
 //-------------------------------------
@@ -375,47 +385,3 @@ func (pvs PrivValidatorsByAddress) Swap(i, j int) {
 	pvs[i] = pvs[j]
 	pvs[j] = it
 }
-
-//-------------------------------------
-
-type checkOnlyDifferByTimestamp func([]byte, []byte) bool
-
-// returns true if the only difference in the votes is their timestamp
-func checkVotesOnlyDifferByTimestamp(lastSignBytes, newSignBytes []byte) bool {
-	var lastVote, newVote CanonicalJSONOnceVote
-	if err := json.Unmarshal(lastSignBytes, &lastVote); err != nil {
-		panic(fmt.Sprintf("LastSignBytes cannot be unmarshalled into vote: %v", err))
-	}
-	if err := json.Unmarshal(newSignBytes, &newVote); err != nil {
-		panic(fmt.Sprintf("signBytes cannot be unmarshalled into vote: %v", err))
-	}
-
-	// set the times to the same value and check equality
-	now := CanonicalTime(time.Now())
-	lastVote.Vote.Timestamp = now
-	newVote.Vote.Timestamp = now
-	lastVoteBytes, _ := json.Marshal(lastVote)
-	newVoteBytes, _ := json.Marshal(newVote)
-
-	return bytes.Equal(newVoteBytes, lastVoteBytes)
-}
-
-// returns true if the only difference in the proposals is their timestamp
-func checkProposalsOnlyDifferByTimestamp(lastSignBytes, newSignBytes []byte) bool {
-	var lastProposal, newProposal CanonicalJSONOnceProposal
-	if err := json.Unmarshal(lastSignBytes, &lastProposal); err != nil {
-		panic(fmt.Sprintf("LastSignBytes cannot be unmarshalled into proposal: %v", err))
-	}
-	if err := json.Unmarshal(newSignBytes, &newProposal); err != nil {
-		panic(fmt.Sprintf("signBytes cannot be unmarshalled into proposal: %v", err))
-	}
-
-	// set the times to the same value and check equality
-	now := CanonicalTime(time.Now())
-	lastProposal.Proposal.Timestamp = now
-	newProposal.Proposal.Timestamp = now
-	lastProposalBytes, _ := json.Marshal(lastProposal)
-	newProposalBytes, _ := json.Marshal(newProposal)
-
-	return bytes.Equal(newProposalBytes, lastProposalBytes)
-}
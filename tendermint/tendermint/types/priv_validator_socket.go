@@ -0,0 +1,409 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	wire "github.com/tendermint/go-wire"
+	data "github.com/tendermint/go-wire/data"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/tendermint/tendermint/p2p"
+)
+
+const (
+	defaultDialRetries        = 3
+	defaultDialRetryIntervalS = 1
+
+	maxRemoteSignerMsgSize = 1024 * 10
+)
+
+var (
+	acceptDeadline = time.Second * 3
+	connDeadline   = time.Second * 3
+	connHeartbeat  = time.Second * 2
+)
+
+// Socket errors.
+var (
+	ErrDialRetryMax   = errors.New("dialed maximum retries")
+	ErrConnTimeout    = errors.New("remote signer timed out")
+	ErrUnexpectedResp = errors.New("remote signer sent unexpected response")
+	ErrUnexpectedPeer = errors.New("secret connection peer does not match the expected pubkey")
+)
+
+// RemoteSignerMsg is sent between SocketPV and the out-of-process
+// RemoteSigner that answers its requests.
+type RemoteSignerMsg interface{}
+
+var _ = wire.RegisterInterface(
+	struct{ RemoteSignerMsg }{},
+	wire.ConcreteType{O: &PubKeyRequest{}, Byte: 0x01},
+	wire.ConcreteType{O: &PubKeyResponse{}, Byte: 0x02},
+	wire.ConcreteType{O: &SignVoteRequest{}, Byte: 0x03},
+	wire.ConcreteType{O: &SignedVoteResponse{}, Byte: 0x04},
+	wire.ConcreteType{O: &SignProposalRequest{}, Byte: 0x05},
+	wire.ConcreteType{O: &SignedProposalResponse{}, Byte: 0x06},
+	wire.ConcreteType{O: &SignHeartbeatRequest{}, Byte: 0x07},
+	wire.ConcreteType{O: &SignedHeartbeatResponse{}, Byte: 0x08},
+	wire.ConcreteType{O: &PingRequest{}, Byte: 0x09},
+	wire.ConcreteType{O: &PingResponse{}, Byte: 0x0A},
+)
+
+// PubKeyRequest asks the remote signer for its public key.
+type PubKeyRequest struct{}
+
+// PubKeyResponse carries the remote signer's public key, or the error that
+// prevented it from being loaded.
+type PubKeyResponse struct {
+	PubKey crypto.PubKey
+	Error  *RemoteSignerError
+}
+
+// SignVoteRequest asks the remote signer to sign a vote.
+type SignVoteRequest struct {
+	Vote *Vote
+}
+
+// SignedVoteResponse carries back the signed vote, or the error that
+// prevented it from being signed (eg. a double-sign attempt).
+type SignedVoteResponse struct {
+	Vote  *Vote
+	Error *RemoteSignerError
+}
+
+// SignProposalRequest asks the remote signer to sign a proposal.
+type SignProposalRequest struct {
+	Proposal *Proposal
+}
+
+// SignedProposalResponse carries back the signed proposal, or the error
+// that prevented it from being signed.
+type SignedProposalResponse struct {
+	Proposal *Proposal
+	Error    *RemoteSignerError
+}
+
+// SignHeartbeatRequest asks the remote signer to sign a heartbeat.
+type SignHeartbeatRequest struct {
+	Heartbeat *Heartbeat
+}
+
+// SignedHeartbeatResponse carries back the signed heartbeat, or the error
+// that prevented it from being signed.
+type SignedHeartbeatResponse struct {
+	Heartbeat *Heartbeat
+	Error     *RemoteSignerError
+}
+
+// PingRequest is a liveness check sent by SocketPV on an idle connection.
+type PingRequest struct{}
+
+// PingResponse acknowledges a PingRequest.
+type PingResponse struct{}
+
+// RemoteSignerError wraps an error returned by the remote signer so it
+// survives the wire round-trip.
+type RemoteSignerError struct {
+	Code        int
+	Description string
+}
+
+func (e *RemoteSignerError) Error() string {
+	return fmt.Sprintf("remote signer error (code: %d): %s", e.Code, e.Description)
+}
+
+// SocketPV implements PrivValidator by delegating signing to an external
+// process reached over an authenticated TCP socket, so the validator key
+// never has to live on the consensus node's disk. SocketPV dials the
+// signer, authenticates it with a Station-to-Station handshake (the same
+// one p2p uses for peer connections), and keeps the double-signing checks
+// entirely on the remote side: this type carries no LastHeight/LastRound/
+// LastStep of its own. The handshake only proves the remote end holds
+// *some* long-term key; expectedPubKey pins that down to the specific
+// signer this node is supposed to be talking to, so a host that merely
+// reaches the listening address can't complete the handshake as if it
+// were the real signer.
+type SocketPV struct {
+	cmn.BaseService
+
+	addr          string
+	connTimeout   time.Duration
+	connHeartbeat time.Duration
+
+	privKey        crypto.PrivKeyEd25519 // ephemeral key used to authenticate us to the signer
+	expectedPubKey crypto.PubKey         // long-term pubkey the remote signer must present
+
+	mtx    sync.Mutex
+	conn   net.Conn
+	pubKey crypto.PubKey
+}
+
+// NewSocketPV returns a SocketPV that will dial addr on Start, using a
+// freshly generated ephemeral key for the secret connection handshake.
+// expectedPubKey is the remote signer's long-term pubkey; the connection
+// is rejected if the handshake authenticates a different one. An empty
+// expectedPubKey disables the check and accepts any remote key.
+func NewSocketPV(addr string, expectedPubKey crypto.PubKey) *SocketPV {
+	sc := &SocketPV{
+		addr:           addr,
+		connTimeout:    connDeadline,
+		connHeartbeat:  connHeartbeat,
+		privKey:        crypto.GenPrivKeyEd25519(),
+		expectedPubKey: expectedPubKey,
+	}
+	sc.BaseService = *cmn.NewBaseService(nil, "SocketPV", sc)
+	return sc
+}
+
+// OnStart implements cmn.Service. It dials the remote signer, retrying a
+// few times before giving up, then starts a background loop that pings it
+// every connHeartbeat so a dead connection is noticed even when nothing
+// happens to need signing at the time.
+func (sc *SocketPV) OnStart() error {
+	conn, err := sc.retryDial()
+	if err != nil {
+		return err
+	}
+	sc.conn = conn
+	go sc.pingLoop()
+	return nil
+}
+
+// pingLoop pings the remote signer on an interval until the service is
+// stopped. request() drops a connection whose round-trip fails and redials
+// on the next call, so a failed ping here needs no special handling: it
+// just means this ping, rather than the next real sign request, is what
+// discovers the connection is gone, and the next request redials.
+func (sc *SocketPV) pingLoop() {
+	ticker := time.NewTicker(sc.connHeartbeat)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !sc.IsRunning() {
+			return
+		}
+		sc.Ping()
+	}
+}
+
+// OnStop implements cmn.Service.
+func (sc *SocketPV) OnStop() {
+	if sc.conn != nil {
+		sc.conn.Close()
+	}
+}
+
+func (sc *SocketPV) retryDial() (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for retries := 0; retries < defaultDialRetries; retries++ {
+		conn, err = sc.dial()
+		if err == nil {
+			return conn, nil
+		}
+		time.Sleep(time.Second * defaultDialRetryIntervalS)
+	}
+	return nil, ErrDialRetryMax
+}
+
+func (sc *SocketPV) dial() (net.Conn, error) {
+	conn, err := cmn.Connect(sc.addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(sc.connTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	secretConn, err := p2p.MakeSecretConnection(conn, sc.privKey)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	remotePubKey := secretConn.RemotePubKey()
+	if !sc.expectedPubKey.Empty() && !remotePubKey.Equals(sc.expectedPubKey) {
+		secretConn.Close()
+		return nil, ErrUnexpectedPeer
+	}
+	return secretConn, nil
+}
+
+func (sc *SocketPV) ensureConnected() error {
+	if sc.conn != nil {
+		return nil
+	}
+	conn, err := sc.retryDial()
+	if err != nil {
+		return err
+	}
+	sc.conn = conn
+	return nil
+}
+
+// request performs a single request/response round-trip with the remote
+// signer. If an earlier failure dropped the connection it first redials
+// (up to defaultDialRetries attempts); a round-trip that fails closes the
+// connection and returns the error without resending the request.
+func (sc *SocketPV) request(req RemoteSignerMsg) (RemoteSignerMsg, error) {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	if err := sc.ensureConnected(); err != nil {
+		return nil, err
+	}
+
+	sc.conn.SetDeadline(time.Now().Add(sc.connTimeout))
+
+	var n int
+	var err error
+	wire.WriteBinary(req, sc.conn, &n, &err)
+	if err != nil {
+		sc.conn.Close()
+		sc.conn = nil
+		return nil, err
+	}
+
+	var res RemoteSignerMsg
+	wire.ReadBinaryPtr(&res, sc.conn, maxRemoteSignerMsgSize, &n, &err)
+	if err != nil {
+		sc.conn.Close()
+		sc.conn = nil
+		return nil, err
+	}
+	return res, nil
+}
+
+// Ping checks that the remote signer is still alive and reachable.
+func (sc *SocketPV) Ping() error {
+	res, err := sc.request(&PingRequest{})
+	if err != nil {
+		return err
+	}
+	if _, ok := res.(*PingResponse); !ok {
+		return ErrUnexpectedResp
+	}
+	return nil
+}
+
+// LoadOrGenPrivValidatorFSOrSocket is the entry point consensus wiring
+// should call in place of LoadOrGenPrivValidatorFS. When laddr is set it
+// dials out to an external signer instead of loading priv_validator.json
+// from disk, so priv_validator.json is never touched in that mode.
+// expectedSignerPubKey pins the remote signer's long-term identity when
+// laddr is set; an empty one disables that check (see NewSocketPV).
+//
+// NOTE: no node/config package exists in this tree to parse a
+// --priv-validator-laddr-style flag and the accompanying expected-pubkey
+// config and call this, so as of this change nothing calls it yet. Wiring
+// it into actual node startup is out of scope for this series and is left
+// to whoever adds the flag.
+func LoadOrGenPrivValidatorFSOrSocket(filePath, laddr string, expectedSignerPubKey crypto.PubKey) PrivValidator {
+	if laddr == "" {
+		return LoadOrGenPrivValidatorFS(filePath)
+	}
+	return NewSocketPV(laddr, expectedSignerPubKey)
+}
+
+// GetAddress implements PrivValidator.
+func (sc *SocketPV) GetAddress() data.Bytes {
+	return sc.GetPubKey().Address()
+}
+
+// GetPubKey implements PrivValidator. It fetches the public key from the
+// remote signer and caches it; if the request fails (eg. a transient
+// network blip) it falls back to the value cached from a previous
+// successful fetch, rather than crashing the node. It only panics if no
+// cached key is available either.
+func (sc *SocketPV) GetPubKey() crypto.PubKey {
+	res, err := sc.request(&PubKeyRequest{})
+	if err == nil {
+		if pubKeyResp, ok := res.(*PubKeyResponse); ok && pubKeyResp.Error == nil {
+			sc.mtx.Lock()
+			sc.pubKey = pubKeyResp.PubKey
+			sc.mtx.Unlock()
+			return pubKeyResp.PubKey
+		}
+	}
+
+	sc.mtx.Lock()
+	cached := sc.pubKey
+	sc.mtx.Unlock()
+	if !cached.Empty() {
+		return cached
+	}
+	cmn.PanicCrisis(cmn.Fmt("SocketPV: failed to fetch pubkey and no cached key available: %v", err))
+	return crypto.PubKey{}
+}
+
+// GetPrikey implements PrivValidator. SocketPV never holds the private key
+// itself, so it always returns an empty one.
+func (sc *SocketPV) GetPrikey() crypto.PrivKey {
+	return crypto.PrivKey{}
+}
+
+// GetPrikeyFromConfigServer implements PrivValidator. Key rotation is the
+// remote signer's responsibility, not the node's, so this is a no-op here.
+func (sc *SocketPV) GetPrikeyFromConfigServer() error {
+	return nil
+}
+
+// ModifyLastHeight implements PrivValidator. Double-signing state lives on
+// the remote signer, so there is nothing to modify on this side.
+func (sc *SocketPV) ModifyLastHeight(h int64) {}
+
+// SignVote implements PrivValidator by asking the remote signer to sign.
+func (sc *SocketPV) SignVote(chainID string, vote *Vote) error {
+	res, err := sc.request(&SignVoteRequest{Vote: vote})
+	if err != nil {
+		return err
+	}
+	voteResp, ok := res.(*SignedVoteResponse)
+	if !ok {
+		return ErrUnexpectedResp
+	}
+	if voteResp.Error != nil {
+		return voteResp.Error
+	}
+	*vote = *voteResp.Vote
+	return nil
+}
+
+// SignProposal implements PrivValidator by asking the remote signer to sign.
+func (sc *SocketPV) SignProposal(chainID string, proposal *Proposal) error {
+	res, err := sc.request(&SignProposalRequest{Proposal: proposal})
+	if err != nil {
+		return err
+	}
+	propResp, ok := res.(*SignedProposalResponse)
+	if !ok {
+		return ErrUnexpectedResp
+	}
+	if propResp.Error != nil {
+		return propResp.Error
+	}
+	*proposal = *propResp.Proposal
+	return nil
+}
+
+// SignHeartbeat implements PrivValidator by asking the remote signer to sign.
+func (sc *SocketPV) SignHeartbeat(chainID string, heartbeat *Heartbeat) error {
+	res, err := sc.request(&SignHeartbeatRequest{Heartbeat: heartbeat})
+	if err != nil {
+		return err
+	}
+	hbResp, ok := res.(*SignedHeartbeatResponse)
+	if !ok {
+		return ErrUnexpectedResp
+	}
+	if hbResp.Error != nil {
+		return hbResp.Error
+	}
+	*heartbeat = *hbResp.Heartbeat
+	return nil
+}
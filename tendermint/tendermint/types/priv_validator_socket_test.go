@@ -0,0 +1,95 @@
+package types
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	crypto "github.com/tendermint/go-crypto"
+	wire "github.com/tendermint/go-wire"
+	cmn "github.com/tendermint/tmlibs/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSocketPVPipe returns a SocketPV already wired to one end of an
+// in-memory net.Pipe, and the other end for a test to act as the remote
+// signer on -- so request/response framing can be exercised without a real
+// dial or secret-connection handshake (p2p isn't part of this tree).
+func newTestSocketPVPipe() (*SocketPV, net.Conn) {
+	clientConn, serverConn := net.Pipe()
+	sc := &SocketPV{
+		addr:          "test",
+		connTimeout:   time.Second,
+		connHeartbeat: time.Hour,
+		privKey:       crypto.GenPrivKeyEd25519(),
+		conn:          clientConn,
+	}
+	sc.BaseService = *cmn.NewBaseService(nil, "SocketPV", sc)
+	return sc, serverConn
+}
+
+// serveOnce reads a single RemoteSignerMsg off conn and writes back
+// whatever respond returns, as the real RemoteSigner's handleConnection
+// loop would for one request.
+func serveOnce(t *testing.T, conn net.Conn, respond func(req RemoteSignerMsg) RemoteSignerMsg) {
+	var n int
+	var err error
+	var req RemoteSignerMsg
+	wire.ReadBinaryPtr(&req, conn, maxRemoteSignerMsgSize, &n, &err)
+	require.NoError(t, err)
+
+	res := respond(req)
+	wire.WriteBinary(res, conn, &n, &err)
+	require.NoError(t, err)
+}
+
+func TestSocketPVPing(t *testing.T) {
+	sc, serverConn := newTestSocketPVPipe()
+	defer serverConn.Close()
+
+	go serveOnce(t, serverConn, func(req RemoteSignerMsg) RemoteSignerMsg {
+		assert.IsType(t, &PingRequest{}, req)
+		return &PingResponse{}
+	})
+
+	assert.NoError(t, sc.Ping())
+}
+
+func TestSocketPVSignVotePropagatesRemoteError(t *testing.T) {
+	sc, serverConn := newTestSocketPVPipe()
+	defer serverConn.Close()
+	vote := &Vote{Height: 1, Round: 0, Type: VoteTypePrecommit, BlockID: BlockID{}}
+
+	go serveOnce(t, serverConn, func(req RemoteSignerMsg) RemoteSignerMsg {
+		_, ok := req.(*SignVoteRequest)
+		require.True(t, ok)
+		return &SignedVoteResponse{Error: &RemoteSignerError{Description: "refused to sign"}}
+	})
+
+	assert.Error(t, sc.SignVote("test-chain", vote))
+}
+
+func TestSocketPVSignVoteFillsInSignature(t *testing.T) {
+	sc, serverConn := newTestSocketPVPipe()
+	defer serverConn.Close()
+	vote := &Vote{Height: 1, Round: 0, Type: VoteTypePrecommit, BlockID: BlockID{}}
+	signed := *vote
+	signed.Signature = crypto.GenPrivKeyEd25519().Wrap().Sign([]byte("whatever"))
+
+	go serveOnce(t, serverConn, func(req RemoteSignerMsg) RemoteSignerMsg {
+		return &SignedVoteResponse{Vote: &signed}
+	})
+
+	require.NoError(t, sc.SignVote("test-chain", vote))
+	assert.Equal(t, signed.Signature, vote.Signature)
+}
+
+func TestSocketPVGetPubKeyFallsBackToCachedKeyOnFailure(t *testing.T) {
+	sc, serverConn := newTestSocketPVPipe()
+	wantKey := crypto.GenPrivKeyEd25519().Wrap().PubKey()
+	sc.pubKey = wantKey
+	serverConn.Close() // any request on sc.conn now fails
+
+	assert.Equal(t, wantKey, sc.GetPubKey())
+}
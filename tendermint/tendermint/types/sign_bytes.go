@@ -0,0 +1,121 @@
+package types
+
+import (
+	"time"
+
+	wire "github.com/tendermint/go-wire"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+// SignedMsgType is the binary tag distinguishing the different kinds of
+// votes in the sign-bytes encoding below. It sits alongside the existing
+// VoteTypePrevote/VoteTypePrecommit vote type constants (used by voteToStep)
+// but is its own explicitly-sized type, so it always serializes to a
+// single byte regardless of how Go chooses to represent an untyped int
+// constant.
+type SignedMsgType byte
+
+const (
+	// PrevoteType is the SignedMsgType for prevotes.
+	PrevoteType SignedMsgType = 0x01
+	// PrecommitType is the SignedMsgType for precommits.
+	PrecommitType SignedMsgType = 0x02
+)
+
+func signedMsgType(voteType int8) SignedMsgType {
+	switch voteType {
+	case VoteTypePrevote:
+		return PrevoteType
+	case VoteTypePrecommit:
+		return PrecommitType
+	default:
+		cmn.PanicSanity("Unknown vote type")
+		return 0
+	}
+}
+
+// voteType is the inverse of signedMsgType, used to rehydrate a Vote from
+// a decoded CanonicalVote (eg. when building DuplicateVoteEvidence from
+// the previous signBytes).
+func voteType(t SignedMsgType) int8 {
+	switch t {
+	case PrevoteType:
+		return VoteTypePrevote
+	case PrecommitType:
+		return VoteTypePrecommit
+	default:
+		cmn.PanicSanity("Unknown SignedMsgType")
+		return 0
+	}
+}
+
+// CanonicalVote is the fixed binary layout signed for a vote: chainID,
+// type, height, round, blockID and timestamp, in that order. It replaces
+// the old CanonicalJSONOnceVote encoding so that signatures are canonical,
+// smaller, and don't depend on encoding/json's field-ordering.
+type CanonicalVote struct {
+	ChainID   string
+	Type      SignedMsgType
+	Height    int64
+	Round     int64
+	BlockID   BlockID
+	Timestamp time.Time
+}
+
+// CanonicalProposal is the fixed binary layout signed for a proposal.
+type CanonicalProposal struct {
+	ChainID   string
+	Height    int64
+	Round     int64
+	POLRound  int64
+	BlockID   BlockID
+	Timestamp time.Time
+}
+
+// SignBytes returns the length-prefixed binary encoding of the vote, for
+// the given chainID, that this validator signs. Implements Signable.
+func (vote *Vote) SignBytes(chainID string) []byte {
+	cv := CanonicalVote{
+		ChainID:   chainID,
+		Type:      signedMsgType(vote.Type),
+		Height:    vote.Height,
+		Round:     int64(vote.Round),
+		BlockID:   vote.BlockID,
+		Timestamp: vote.Timestamp,
+	}
+	return wire.BinaryBytes(cv)
+}
+
+// SignBytes returns the length-prefixed binary encoding of the proposal,
+// for the given chainID, that this validator signs. Implements Signable.
+func (proposal *Proposal) SignBytes(chainID string) []byte {
+	cp := CanonicalProposal{
+		ChainID:   chainID,
+		Height:    proposal.Height,
+		Round:     int64(proposal.Round),
+		POLRound:  int64(proposal.POLRound),
+		BlockID:   proposal.BlockID,
+		Timestamp: proposal.Timestamp,
+	}
+	return wire.BinaryBytes(cp)
+}
+
+// SignBytes returns the length-prefixed binary encoding of the heartbeat,
+// for the given chainID, that this validator signs. Implements Signable.
+func (heartbeat *Heartbeat) SignBytes(chainID string) []byte {
+	return wire.BinaryBytes(struct {
+		ChainID string
+		*Heartbeat
+	}{chainID, heartbeat})
+}
+
+// Signable is implemented by the messages a PrivValidator signs: votes,
+// proposals and heartbeats.
+type Signable interface {
+	SignBytes(chainID string) []byte
+}
+
+// SignBytes returns the canonical bytes to sign for o, given chainID.
+func SignBytes(chainID string, o Signable) []byte {
+	return o.SignBytes(chainID)
+}
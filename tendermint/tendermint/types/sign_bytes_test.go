@@ -0,0 +1,53 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	wire "github.com/tendermint/go-wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoteSignBytesRoundTrip(t *testing.T) {
+	vote := &Vote{
+		Height:    12345,
+		Round:     2,
+		Type:      VoteTypePrecommit,
+		BlockID:   BlockID{},
+		Timestamp: time.Now(),
+	}
+	signBytes := vote.SignBytes("test-chain")
+
+	var cv CanonicalVote
+	require.NoError(t, wire.ReadBinaryBytes(signBytes, &cv))
+	assert.Equal(t, "test-chain", cv.ChainID)
+	assert.Equal(t, PrecommitType, cv.Type)
+	assert.EqualValues(t, vote.Height, cv.Height)
+	assert.EqualValues(t, vote.Round, cv.Round)
+}
+
+func TestProposalSignBytesRoundTrip(t *testing.T) {
+	proposal := &Proposal{
+		Height:    12345,
+		Round:     2,
+		POLRound:  1,
+		BlockID:   BlockID{},
+		Timestamp: time.Now(),
+	}
+	signBytes := proposal.SignBytes("test-chain")
+
+	var cp CanonicalProposal
+	require.NoError(t, wire.ReadBinaryBytes(signBytes, &cp))
+	assert.Equal(t, "test-chain", cp.ChainID)
+	assert.EqualValues(t, proposal.Height, cp.Height)
+	assert.EqualValues(t, proposal.Round, cp.Round)
+	assert.EqualValues(t, proposal.POLRound, cp.POLRound)
+}
+
+func TestSignedMsgTypeRoundTrip(t *testing.T) {
+	assert.Equal(t, PrevoteType, signedMsgType(VoteTypePrevote))
+	assert.Equal(t, PrecommitType, signedMsgType(VoteTypePrecommit))
+	assert.Equal(t, int8(VoteTypePrevote), voteType(PrevoteType))
+	assert.Equal(t, int8(VoteTypePrecommit), voteType(PrecommitType))
+}